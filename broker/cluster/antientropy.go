@@ -0,0 +1,174 @@
+/**********************************************************************************
+* Copyright (c) 2009-2017 Misakai Ltd.
+* This program is free software: you can redistribute it and/or modify it under the
+* terms of the GNU Affero General Public License as published by the  Free Software
+* Foundation, either version 3 of the License, or(at your option) any later version.
+*
+* This program is distributed  in the hope that it  will be useful, but WITHOUT ANY
+* WARRANTY;  without even  the implied warranty of MERCHANTABILITY or FITNESS FOR A
+* PARTICULAR PURPOSE.  See the GNU Affero General Public License  for  more details.
+*
+* You should have  received a copy  of the  GNU Affero General Public License along
+* with this program. If not, see<http://www.gnu.org/licenses/>.
+************************************************************************************/
+
+package cluster
+
+import (
+	"crypto/sha1"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/weaveworks/mesh"
+)
+
+// defaultAntiEntropyInterval is used whenever the cluster configuration
+// leaves AntiEntropyInterval unset.
+const defaultAntiEntropyInterval = 10 * time.Second
+
+// digestID is a content hash of a single encoded subscriptionState key,
+// compact enough to exchange in bulk without shipping the full event.
+type digestID [sha1.Size]byte
+
+func newDigestID(key string) digestID {
+	return digestID(sha1.Sum([]byte(key)))
+}
+
+// encodeDigest serialises a list of digest IDs behind an opcode byte.
+func encodeDigest(op byte, ids []digestID) []byte {
+	buf := make([]byte, 1, 1+len(ids)*sha1.Size)
+	buf[0] = op
+	for _, id := range ids {
+		buf = append(buf, id[:]...)
+	}
+	return buf
+}
+
+// decodeDigest parses the body of an opDigestPull/opDigestWant message, once
+// the caller has already stripped the leading opcode byte.
+func decodeDigest(body []byte) []digestID {
+	ids := make([]digestID, 0, len(body)/sha1.Size)
+	for len(body) >= sha1.Size {
+		var id digestID
+		copy(id[:], body[:sha1.Size])
+		ids = append(ids, id)
+		body = body[sha1.Size:]
+	}
+	return ids
+}
+
+// digestOf builds a lookup from digestID back to the full event key for
+// everything currently in the state, so a "want" request can be answered.
+func (s *Swarm) digestOf() map[digestID]string {
+	all := s.state.All()
+	out := make(map[digestID]string, len(all))
+	for k := range all {
+		out[newDigestID(k)] = k
+	}
+	return out
+}
+
+// antiEntropyCounters exposes the exchange counts as metrics.
+type antiEntropyCounters struct {
+	Pulls   uint64 // Number of anti-entropy rounds initiated.
+	Sent    uint64 // Number of events sent in response to a want.
+	Learned uint64 // Number of events learned from a peer's response.
+}
+
+// Counters returns a snapshot of the anti-entropy exchange counters.
+func (s *Swarm) Counters() antiEntropyCounters {
+	return antiEntropyCounters{
+		Pulls:   atomic.LoadUint64(&s.antiEntropyPulls),
+		Sent:    atomic.LoadUint64(&s.antiEntropySent),
+		Learned: atomic.LoadUint64(&s.antiEntropyLearned),
+	}
+}
+
+// antiEntropyPull runs one round of pull-based anti-entropy: pick a random
+// neighbor, send it a digest of everything we know, and let it tell us what
+// we're missing. This lets the cluster self-heal when a GossipBroadcast is
+// dropped or a peer transiently partitions, which merge() alone can't
+// recover from without a subscription churning again.
+func (s *Swarm) antiEntropyPull() {
+	candidates := s.peerNames()
+	if len(candidates) == 0 {
+		return
+	}
+
+	atomic.AddUint64(&s.antiEntropyPulls, 1)
+	peer := candidates[rand.Intn(len(candidates))]
+
+	digest := s.digestOf()
+	ids := make([]digestID, 0, len(digest))
+	for id := range digest {
+		ids = append(ids, id)
+	}
+
+	s.gossip.GossipUnicast(peer, encodeDigest(opDigestPull, ids))
+}
+
+// onDigestPull handles an incoming digest from src: anything in our own
+// state that isn't represented in the digest gets pushed back as an
+// anti-entropy reply, and anything in the digest we don't recognise gets requested with a
+// digestWant.
+func (s *Swarm) onDigestPull(src mesh.PeerName, ids []digestID) {
+	theirs := make(map[digestID]bool, len(ids))
+	for _, id := range ids {
+		theirs[id] = true
+	}
+
+	missingForThem := newSubscriptionState()
+	all := s.state.All()
+	for k, v := range all {
+		if !theirs[newDigestID(k)] {
+			if v.IsAdded() {
+				missingForThem.Add(k)
+			} else {
+				missingForThem.Remove(k)
+			}
+		}
+	}
+	for _, buf := range missingForThem.Encode() {
+		s.gossip.GossipUnicast(src, append([]byte{opAntiEntropyData}, buf...))
+	}
+
+	ours := s.digestOf()
+	want := make([]digestID, 0)
+	for _, id := range ids {
+		if _, ok := ours[id]; !ok {
+			want = append(want, id)
+		}
+	}
+	if len(want) > 0 {
+		s.gossip.GossipUnicast(src, encodeDigest(opDigestWant, want))
+	}
+}
+
+// onDigestWant handles a request for specific digest IDs, replying with the
+// full encoded events for the ones we still have.
+func (s *Swarm) onDigestWant(src mesh.PeerName, ids []digestID) {
+	ours := s.digestOf()
+	all := s.state.All()
+
+	reply := newSubscriptionState()
+	sent := uint64(0)
+	for _, id := range ids {
+		if k, ok := ours[id]; ok {
+			if v := all[k]; v.IsAdded() {
+				reply.Add(k)
+			} else {
+				reply.Remove(k)
+			}
+			sent++
+		}
+	}
+	if sent == 0 {
+		return
+	}
+
+	atomic.AddUint64(&s.antiEntropySent, sent)
+	for _, buf := range reply.Encode() {
+		s.gossip.GossipUnicast(src, append([]byte{opAntiEntropyData}, buf...))
+	}
+}
@@ -0,0 +1,34 @@
+/**********************************************************************************
+* Copyright (c) 2009-2017 Misakai Ltd.
+* This program is free software: you can redistribute it and/or modify it under the
+* terms of the GNU Affero General Public License as published by the  Free Software
+* Foundation, either version 3 of the License, or(at your option) any later version.
+*
+* This program is distributed  in the hope that it  will be useful, but WITHOUT ANY
+* WARRANTY;  without even  the implied warranty of MERCHANTABILITY or FITNESS FOR A
+* PARTICULAR PURPOSE.  See the GNU Affero General Public License  for  more details.
+*
+* You should have  received a copy  of the  GNU Affero General Public License along
+* with this program. If not, see<http://www.gnu.org/licenses/>.
+************************************************************************************/
+
+package cluster
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecodeDigest(t *testing.T) {
+	ids := []digestID{newDigestID("a"), newDigestID("b"), newDigestID("c")}
+	buf := encodeDigest(opDigestPull, ids)
+
+	assert.Equal(t, opDigestPull, buf[0])
+	assert.Equal(t, ids, decodeDigest(buf[1:]))
+}
+
+func TestNewDigestID_Stable(t *testing.T) {
+	assert.Equal(t, newDigestID("same"), newDigestID("same"))
+	assert.NotEqual(t, newDigestID("a"), newDigestID("b"))
+}
@@ -0,0 +1,160 @@
+/**********************************************************************************
+* Copyright (c) 2009-2017 Misakai Ltd.
+* This program is free software: you can redistribute it and/or modify it under the
+* terms of the GNU Affero General Public License as published by the  Free Software
+* Foundation, either version 3 of the License, or(at your option) any later version.
+*
+* This program is distributed  in the hope that it  will be useful, but WITHOUT ANY
+* WARRANTY;  without even  the implied warranty of MERCHANTABILITY or FITNESS FOR A
+* PARTICULAR PURPOSE.  See the GNU Affero General Public License  for  more details.
+*
+* You should have  received a copy  of the  GNU Affero General Public License along
+* with this program. If not, see<http://www.gnu.org/licenses/>.
+************************************************************************************/
+
+package cluster
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"sync"
+
+	"github.com/emitter-io/emitter/broker/message"
+)
+
+// defaultCacheWindows is the number of sliding time windows the cache keeps,
+// and defaultCacheGossip is how many of the newest windows are advertised in
+// an IHAVE. Older windows are kept only to answer IWANT for messages that
+// just fell out of the advertised range.
+const (
+	defaultCacheWindows = 5
+	defaultCacheGossip  = 3
+)
+
+// MessageCache stores recently seen message frames across a small number of
+// sliding time windows, keyed by a stable MessageID. It lets Swarm dedup
+// inbound frames and answer IWANT requests without depending on the
+// underlying TCP connection for uniqueness, which no longer holds once
+// frames can arrive over multiple mesh paths.
+type MessageCache struct {
+	sync.Mutex
+	windows []map[messageID]*message.Message
+	gossip  int // How many of the newest windows get advertised via IHAVE.
+}
+
+// NewMessageCache creates a new, empty message cache with `windows` sliding
+// windows, of which the newest `gossip` are advertised via IHAVE.
+func NewMessageCache(windows, gossip int) *MessageCache {
+	if windows <= 0 {
+		windows = defaultCacheWindows
+	}
+	if gossip <= 0 || gossip > windows {
+		gossip = defaultCacheGossip
+	}
+	if gossip > windows {
+		gossip = windows
+	}
+
+	c := &MessageCache{
+		windows: make([]map[messageID]*message.Message, windows),
+		gossip:  gossip,
+	}
+	for i := range c.windows {
+		c.windows[i] = make(map[messageID]*message.Message)
+	}
+	return c
+}
+
+// newMessageID computes a stable ID for a message, derived from its ssid,
+// payload and timestamp so that redundant deliveries over different mesh
+// paths hash to the same ID.
+func newMessageID(m *message.Message) messageID {
+	h := sha1.New()
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(m.Time))
+	h.Write(buf)
+	for _, p := range m.Ssid {
+		binary.BigEndian.PutUint32(buf[:4], p)
+		h.Write(buf[:4])
+	}
+	h.Write(m.Payload)
+
+	var id messageID
+	copy(id[:], h.Sum(nil))
+	return id
+}
+
+// Put stores a message in the newest window, keyed by its computed ID, and
+// returns the ID it was stored under.
+func (c *MessageCache) Put(m *message.Message) messageID {
+	id := newMessageID(m)
+	c.Lock()
+	c.windows[0][id] = m
+	c.Unlock()
+	return id
+}
+
+// PutIfAbsent stores a message unless its ID is already present in any
+// window, in which case it's left untouched. It returns the message's ID and
+// whether this call was the one that stored it. The lookup and the store
+// happen under a single critical section, so two goroutines racing on the
+// same ID (the same frame arriving from multiple eager-mesh peers at once)
+// can't both observe "absent" and both win the store.
+func (c *MessageCache) PutIfAbsent(m *message.Message) (messageID, bool) {
+	id := newMessageID(m)
+
+	c.Lock()
+	defer c.Unlock()
+	for _, w := range c.windows {
+		if _, ok := w[id]; ok {
+			return id, false
+		}
+	}
+
+	c.windows[0][id] = m
+	return id, true
+}
+
+// Get looks a message up by ID across every window, newest first.
+func (c *MessageCache) Get(id messageID) (*message.Message, bool) {
+	c.Lock()
+	defer c.Unlock()
+	for _, w := range c.windows {
+		if m, ok := w[id]; ok {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// Has reports whether the ID is already present in the cache, without
+// fetching the message body.
+func (c *MessageCache) Has(id messageID) bool {
+	_, ok := c.Get(id)
+	return ok
+}
+
+// Shift rotates the cache by one window: a fresh window becomes the newest,
+// and the oldest window is dropped. This is called periodically so the
+// cache only ever holds recent history.
+func (c *MessageCache) Shift() {
+	c.Lock()
+	defer c.Unlock()
+	copy(c.windows[1:], c.windows[:len(c.windows)-1])
+	c.windows[0] = make(map[messageID]*message.Message)
+}
+
+// RecentIDs returns the IDs stored in the newest `gossip` windows, suitable
+// for advertising via IHAVE.
+func (c *MessageCache) RecentIDs() []messageID {
+	c.Lock()
+	defer c.Unlock()
+
+	ids := make([]messageID, 0)
+	for _, w := range c.windows[:c.gossip] {
+		for id := range w {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,88 @@
+/**********************************************************************************
+* Copyright (c) 2009-2017 Misakai Ltd.
+* This program is free software: you can redistribute it and/or modify it under the
+* terms of the GNU Affero General Public License as published by the  Free Software
+* Foundation, either version 3 of the License, or(at your option) any later version.
+*
+* This program is distributed  in the hope that it  will be useful, but WITHOUT ANY
+* WARRANTY;  without even  the implied warranty of MERCHANTABILITY or FITNESS FOR A
+* PARTICULAR PURPOSE.  See the GNU Affero General Public License  for  more details.
+*
+* You should have  received a copy  of the  GNU Affero General Public License along
+* with this program. If not, see<http://www.gnu.org/licenses/>.
+************************************************************************************/
+
+package cluster
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/emitter-io/emitter/broker/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageCache_PutGet(t *testing.T) {
+	c := NewMessageCache(3, 2)
+	m := &message.Message{Ssid: []uint32{1, 2, 3}, Payload: []byte("hello"), Time: 42}
+
+	id := c.Put(m)
+	found, ok := c.Get(id)
+	assert.True(t, ok)
+	assert.Equal(t, m, found)
+}
+
+func TestMessageCache_Shift(t *testing.T) {
+	c := NewMessageCache(3, 2)
+	m := &message.Message{Ssid: []uint32{1}, Payload: []byte("first"), Time: 1}
+	id := c.Put(m)
+
+	// Still reachable after one shift, since it moves into an older window.
+	c.Shift()
+	_, ok := c.Get(id)
+	assert.True(t, ok)
+
+	// But not after it's been pushed out of every window.
+	c.Shift()
+	c.Shift()
+	_, ok = c.Get(id)
+	assert.False(t, ok)
+}
+
+func TestMessageCache_PutIfAbsentConcurrent(t *testing.T) {
+	c := NewMessageCache(3, 2)
+	m := &message.Message{Ssid: []uint32{1, 2, 3}, Payload: []byte("hello"), Time: 42}
+
+	const racers = 50
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	won := 0
+	wg.Add(racers)
+	for i := 0; i < racers; i++ {
+		go func() {
+			defer wg.Done()
+			if _, isNew := c.PutIfAbsent(m); isNew {
+				mu.Lock()
+				won++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, won, "exactly one caller should win the store for a given ID")
+}
+
+func TestMessageCache_RecentIDs(t *testing.T) {
+	c := NewMessageCache(5, 3)
+	a := c.Put(&message.Message{Ssid: []uint32{1}, Payload: []byte("a"), Time: 1})
+	c.Shift()
+	c.Shift()
+	c.Shift()
+	c.Shift() // now in the oldest (5th) window, outside the 3-window gossip range
+
+	ids := c.RecentIDs()
+	for _, id := range ids {
+		assert.NotEqual(t, a, id)
+	}
+}
@@ -0,0 +1,82 @@
+/**********************************************************************************
+* Copyright (c) 2009-2017 Misakai Ltd.
+* This program is free software: you can redistribute it and/or modify it under the
+* terms of the GNU Affero General Public License as published by the  Free Software
+* Foundation, either version 3 of the License, or(at your option) any later version.
+*
+* This program is distributed  in the hope that it  will be useful, but WITHOUT ANY
+* WARRANTY;  without even  the implied warranty of MERCHANTABILITY or FITNESS FOR A
+* PARTICULAR PURPOSE.  See the GNU Affero General Public License  for  more details.
+*
+* You should have  received a copy  of the  GNU Affero General Public License along
+* with this program. If not, see<http://www.gnu.org/licenses/>.
+************************************************************************************/
+
+package cluster
+
+// messageID uniquely identifies a message frame for the purposes of
+// deduplication and the lazy IHAVE/IWANT pull protocol.
+type messageID [16]byte
+
+// Control opcodes, along with opHello/opState/opDigestPull/opDigestWant/
+// opAntiEntropyData/opFrame below, identify the kind of envelope carried
+// over a gossip unicast. Every payload on that channel is tagged this way;
+// the opcode is always the first byte.
+const (
+	opGraft byte = iota + 1
+	opPrune
+	opIHave
+	opIWant
+	opHello           // A signed hello advertising the sender's Ed25519 public key.
+	opState           // A full or historical subscriptionState exchange.
+	opDigestPull      // An anti-entropy digest, offered for comparison.
+	opDigestWant      // A request for specific digest IDs the sender is missing.
+	opAntiEntropyData // A subscriptionState reply to a digest pull or want.
+	opFrame           // A forwarded message.Frame, answering an IWANT or relayed through the eager mesh.
+)
+
+// control is the envelope used for mesh maintenance (GRAFT/PRUNE) and the
+// IHAVE/IWANT gossip-pull protocol.
+type control struct {
+	op  byte
+	ids []messageID
+}
+
+// Encode serialises a control envelope as a single opcode byte followed by
+// the concatenated message IDs.
+func (c control) Encode() []byte {
+	buf := make([]byte, 1, 1+len(c.ids)*len(messageID{}))
+	buf[0] = c.op
+	for _, id := range c.ids {
+		buf = append(buf, id[:]...)
+	}
+	return buf
+}
+
+// decodeControl attempts to parse buf as a GRAFT/PRUNE/IHAVE/IWANT envelope.
+// It returns false if buf does not start with one of those opcodes; callers
+// are expected to have already dispatched on buf[0] to get here.
+func decodeControl(buf []byte) (control, bool) {
+	if len(buf) == 0 {
+		return control{}, false
+	}
+
+	op := buf[0]
+	switch op {
+	case opGraft, opPrune, opIHave, opIWant:
+	default:
+		return control{}, false
+	}
+
+	const idSize = len(messageID{})
+	body := buf[1:]
+	ids := make([]messageID, 0, len(body)/idSize)
+	for len(body) >= idSize {
+		var id messageID
+		copy(id[:], body[:idSize])
+		ids = append(ids, id)
+		body = body[idSize:]
+	}
+
+	return control{op: op, ids: ids}, true
+}
@@ -0,0 +1,58 @@
+/**********************************************************************************
+* Copyright (c) 2009-2017 Misakai Ltd.
+* This program is free software: you can redistribute it and/or modify it under the
+* terms of the GNU Affero General Public License as published by the  Free Software
+* Foundation, either version 3 of the License, or(at your option) any later version.
+*
+* This program is distributed  in the hope that it  will be useful, but WITHOUT ANY
+* WARRANTY;  without even  the implied warranty of MERCHANTABILITY or FITNESS FOR A
+* PARTICULAR PURPOSE.  See the GNU Affero General Public License  for  more details.
+*
+* You should have  received a copy  of the  GNU Affero General Public License along
+* with this program. If not, see<http://www.gnu.org/licenses/>.
+************************************************************************************/
+
+package cluster
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+
+	"github.com/emitter-io/emitter/config"
+)
+
+// loadClusterKey resolves the local node's Ed25519 identity. It prefers an
+// inline hex-encoded seed in cfg.ClusterKey, falls back to reading
+// cfg.ClusterKeyFile, and otherwise generates and returns a fresh, ephemeral
+// keypair so a single node can still start without any configuration.
+func loadClusterKey(cfg *config.ClusterConfig) (ed25519.PrivateKey, error) {
+	seedHex := strings.TrimSpace(cfg.ClusterKey)
+	if seedHex == "" && cfg.ClusterKeyFile != "" {
+		b, err := ioutil.ReadFile(cfg.ClusterKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		seedHex = strings.TrimSpace(string(b))
+	}
+
+	if seedHex == "" {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	}
+
+	seed, err := hex.DecodeString(seedHex)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// sign appends an Ed25519 signature of buf, computed with the local node's
+// private key, to the end of buf.
+func (s *Swarm) sign(buf []byte) []byte {
+	sig := ed25519.Sign(s.key, buf)
+	return append(buf, sig...)
+}
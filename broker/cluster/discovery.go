@@ -0,0 +1,138 @@
+/**********************************************************************************
+* Copyright (c) 2009-2017 Misakai Ltd.
+* This program is free software: you can redistribute it and/or modify it under the
+* terms of the GNU Affero General Public License as published by the  Free Software
+* Foundation, either version 3 of the License, or(at your option) any later version.
+*
+* This program is distributed  in the hope that it  will be useful, but WITHOUT ANY
+* WARRANTY;  without even  the implied warranty of MERCHANTABILITY or FITNESS FOR A
+* PARTICULAR PURPOSE.  See the GNU Affero General Public License  for  more details.
+*
+* You should have  received a copy  of the  GNU Affero General Public License along
+* with this program. If not, see<http://www.gnu.org/licenses/>.
+************************************************************************************/
+
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/emitter-io/emitter/config"
+	"github.com/emitter-io/emitter/logging"
+	"github.com/fsnotify/fsnotify"
+)
+
+// Discovery resolves the set of peer addresses that make up the cluster. It
+// exists so the broker can be deployed somewhere pod IPs change and there's
+// no stable bootstrap peer to gossip from, such as Kubernetes or Nomad, where
+// the one-shot CLI --join and the "public" address trick aren't enough.
+type Discovery interface {
+	// Resolve returns the current set of peer addresses.
+	Resolve() ([]string, error)
+}
+
+// watchableDiscovery is implemented by Discovery providers that can push an
+// immediate notification when their underlying source changes (fileDiscovery
+// via fsnotify), so Swarm can react right away instead of waiting for
+// update's next poll.
+type watchableDiscovery interface {
+	Changed() <-chan fsnotify.Event
+}
+
+// NewDiscovery creates the configured Discovery provider. It defaults to the
+// static provider backed by cfg.Discovery.Static when no provider is
+// configured.
+func NewDiscovery(cfg *config.ClusterConfig) (Discovery, error) {
+	d := cfg.Discovery
+	switch d.Provider {
+	case "", "static":
+		return newStaticDiscovery(d.Static), nil
+	case "dns":
+		return newDNSDiscovery(d.DNS)
+	case "file":
+		return newFileDiscovery(d.File)
+	case "http":
+		return newHTTPDiscovery(d.HTTP), nil
+	default:
+		return nil, fmt.Errorf("cluster: unknown discovery provider %q", d.Provider)
+	}
+}
+
+// staticDiscovery returns a fixed, unchanging set of peer addresses.
+type staticDiscovery struct {
+	peers []string
+}
+
+func newStaticDiscovery(peers []string) *staticDiscovery {
+	return &staticDiscovery{peers: peers}
+}
+
+// Resolve implements Discovery.
+func (d *staticDiscovery) Resolve() ([]string, error) {
+	return d.peers, nil
+}
+
+// discover runs the configured Discovery provider and reconciles its result
+// against our current membership: new addresses are passed to
+// ConnectionMaker.InitiateConnections, while addresses that have disappeared
+// are simply forgotten and left to Swarm.update's existing liveness check to
+// reap once they stop responding. It's called periodically by Swarm.update.
+func (s *Swarm) discover() {
+	if s.discovery == nil {
+		return
+	}
+
+	addrs, err := s.discovery.Resolve()
+	if err != nil {
+		logging.LogError("swarm", "resolving discovery", err)
+		return
+	}
+
+	resolved := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		resolved[addr] = true
+	}
+
+	s.discovered.Range(func(k, v interface{}) bool {
+		addr := k.(string)
+		if !resolved[addr] {
+			s.discovered.Delete(addr)
+			logging.LogTarget("swarm", "discovered peer removed", addr)
+		}
+		return true
+	})
+
+	var added []string
+	for addr := range resolved {
+		if _, ok := s.discovered.LoadOrStore(addr, true); !ok {
+			added = append(added, addr)
+		}
+	}
+
+	if len(added) == 0 {
+		return
+	}
+	if errs := s.router.ConnectionMaker.InitiateConnections(added, false); len(errs) > 0 {
+		for _, err := range errs {
+			logging.LogError("swarm", "connecting to discovered peer", err)
+		}
+	}
+}
+
+// watchDiscovery reacts to change notifications from a watchableDiscovery
+// provider by immediately re-running discover(), instead of waiting for
+// update's next 5 second tick. It returns once the watcher's channel closes
+// or the swarm starts closing.
+func (s *Swarm) watchDiscovery(w watchableDiscovery) {
+	for {
+		select {
+		case _, ok := <-w.Changed():
+			if !ok {
+				return
+			}
+			s.discover()
+		case <-s.closing:
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+/**********************************************************************************
+* Copyright (c) 2009-2017 Misakai Ltd.
+* This program is free software: you can redistribute it and/or modify it under the
+* terms of the GNU Affero General Public License as published by the  Free Software
+* Foundation, either version 3 of the License, or(at your option) any later version.
+*
+* This program is distributed  in the hope that it  will be useful, but WITHOUT ANY
+* WARRANTY;  without even  the implied warranty of MERCHANTABILITY or FITNESS FOR A
+* PARTICULAR PURPOSE.  See the GNU Affero General Public License  for  more details.
+*
+* You should have  received a copy  of the  GNU Affero General Public License along
+* with this program. If not, see<http://www.gnu.org/licenses/>.
+************************************************************************************/
+
+package cluster
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/emitter-io/emitter/config"
+)
+
+// dnsDiscovery resolves peer addresses from a DNS SRV record (or, if the
+// record isn't an SRV name, from a plain A/AAAA lookup), re-resolving on
+// every call so changing pod IPs are picked up without a restart.
+type dnsDiscovery struct {
+	cfg config.DNSDiscoveryConfig
+}
+
+func newDNSDiscovery(cfg config.DNSDiscoveryConfig) (*dnsDiscovery, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("cluster: dns discovery requires a name")
+	}
+	return &dnsDiscovery{cfg: cfg}, nil
+}
+
+// Resolve implements Discovery.
+func (d *dnsDiscovery) Resolve() ([]string, error) {
+	if d.cfg.SRV {
+		_, records, err := net.LookupSRV("", "", d.cfg.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		addrs := make([]string, 0, len(records))
+		for _, r := range records {
+			addrs = append(addrs, fmt.Sprintf("%s:%d", r.Target, r.Port))
+		}
+		return addrs, nil
+	}
+
+	ips, err := net.LookupHost(d.cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, fmt.Sprintf("%s:%d", ip, d.cfg.Port))
+	}
+	return addrs, nil
+}
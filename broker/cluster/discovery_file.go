@@ -0,0 +1,85 @@
+/**********************************************************************************
+* Copyright (c) 2009-2017 Misakai Ltd.
+* This program is free software: you can redistribute it and/or modify it under the
+* terms of the GNU Affero General Public License as published by the  Free Software
+* Foundation, either version 3 of the License, or(at your option) any later version.
+*
+* This program is distributed  in the hope that it  will be useful, but WITHOUT ANY
+* WARRANTY;  without even  the implied warranty of MERCHANTABILITY or FITNESS FOR A
+* PARTICULAR PURPOSE.  See the GNU Affero General Public License  for  more details.
+*
+* You should have  received a copy  of the  GNU Affero General Public License along
+* with this program. If not, see<http://www.gnu.org/licenses/>.
+************************************************************************************/
+
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/emitter-io/emitter/config"
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v2"
+)
+
+// fileDiscovery reads the peer list from a JSON or YAML file of the form
+// `["10.0.0.1:4000", "10.0.0.2:4000"]`, re-reading it whenever fsnotify
+// reports a change so Resolve always returns the latest contents.
+type fileDiscovery struct {
+	path  string
+	watch *fsnotify.Watcher
+}
+
+func newFileDiscovery(cfg config.FileDiscoveryConfig) (*fileDiscovery, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("cluster: file discovery requires a path")
+	}
+
+	watch, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watch.Add(cfg.Path); err != nil {
+		watch.Close()
+		return nil, err
+	}
+
+	return &fileDiscovery{path: cfg.Path, watch: watch}, nil
+}
+
+// Resolve implements Discovery. It re-reads the file on every call, parsing
+// it as YAML when the path ends in .yaml/.yml and as JSON otherwise; Swarm
+// also watches Changed() to re-resolve as soon as fsnotify reports a write,
+// rather than waiting for the next poll.
+func (d *fileDiscovery) Resolve() ([]string, error) {
+	b, err := ioutil.ReadFile(d.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var peers []string
+	switch strings.ToLower(filepath.Ext(d.path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &peers)
+	default:
+		err = json.Unmarshal(b, &peers)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
+
+// Changed returns the fsnotify event channel for the watched file.
+func (d *fileDiscovery) Changed() <-chan fsnotify.Event {
+	return d.watch.Events
+}
+
+// Close releases the underlying fsnotify watcher.
+func (d *fileDiscovery) Close() error {
+	return d.watch.Close()
+}
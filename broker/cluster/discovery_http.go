@@ -0,0 +1,52 @@
+/**********************************************************************************
+* Copyright (c) 2009-2017 Misakai Ltd.
+* This program is free software: you can redistribute it and/or modify it under the
+* terms of the GNU Affero General Public License as published by the  Free Software
+* Foundation, either version 3 of the License, or(at your option) any later version.
+*
+* This program is distributed  in the hope that it  will be useful, but WITHOUT ANY
+* WARRANTY;  without even  the implied warranty of MERCHANTABILITY or FITNESS FOR A
+* PARTICULAR PURPOSE.  See the GNU Affero General Public License  for  more details.
+*
+* You should have  received a copy  of the  GNU Affero General Public License along
+* with this program. If not, see<http://www.gnu.org/licenses/>.
+************************************************************************************/
+
+package cluster
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/emitter-io/emitter/config"
+)
+
+// httpDiscovery polls a URL that returns a JSON array of peer addresses,
+// e.g. `["10.0.0.1:4000", "10.0.0.2:4000"]`.
+type httpDiscovery struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPDiscovery(cfg config.HTTPDiscoveryConfig) *httpDiscovery {
+	return &httpDiscovery{
+		url:    cfg.URL,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Resolve implements Discovery.
+func (d *httpDiscovery) Resolve() ([]string, error) {
+	resp, err := d.client.Get(d.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var peers []string
+	if err := json.NewDecoder(resp.Body).Decode(&peers); err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
@@ -0,0 +1,40 @@
+/**********************************************************************************
+* Copyright (c) 2009-2017 Misakai Ltd.
+* This program is free software: you can redistribute it and/or modify it under the
+* terms of the GNU Affero General Public License as published by the  Free Software
+* Foundation, either version 3 of the License, or(at your option) any later version.
+*
+* This program is distributed  in the hope that it  will be useful, but WITHOUT ANY
+* WARRANTY;  without even  the implied warranty of MERCHANTABILITY or FITNESS FOR A
+* PARTICULAR PURPOSE.  See the GNU Affero General Public License  for  more details.
+*
+* You should have  received a copy  of the  GNU Affero General Public License along
+* with this program. If not, see<http://www.gnu.org/licenses/>.
+************************************************************************************/
+
+package cluster
+
+import (
+	"testing"
+
+	"github.com/emitter-io/emitter/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDiscovery_DefaultsToStatic(t *testing.T) {
+	d, err := NewDiscovery(&config.ClusterConfig{
+		Discovery: config.DiscoveryConfig{Static: []string{"10.0.0.1:4000"}},
+	})
+	assert.NoError(t, err)
+
+	addrs, err := d.Resolve()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:4000"}, addrs)
+}
+
+func TestNewDiscovery_UnknownProvider(t *testing.T) {
+	_, err := NewDiscovery(&config.ClusterConfig{
+		Discovery: config.DiscoveryConfig{Provider: "carrier-pigeon"},
+	})
+	assert.Error(t, err)
+}
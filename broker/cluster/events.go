@@ -0,0 +1,206 @@
+/**********************************************************************************
+* Copyright (c) 2009-2017 Misakai Ltd.
+* This program is free software: you can redistribute it and/or modify it under the
+* terms of the GNU Affero General Public License as published by the  Free Software
+* Foundation, either version 3 of the License, or(at your option) any later version.
+*
+* This program is distributed  in the hope that it  will be useful, but WITHOUT ANY
+* WARRANTY;  without even  the implied warranty of MERCHANTABILITY or FITNESS FOR A
+* PARTICULAR PURPOSE.  See the GNU Affero General Public License  for  more details.
+*
+* You should have  received a copy  of the  GNU Affero General Public License along
+* with this program. If not, see<http://www.gnu.org/licenses/>.
+************************************************************************************/
+
+package cluster
+
+import (
+	"sync"
+
+	"github.com/emitter-io/emitter/broker/subscription"
+	"github.com/weaveworks/mesh"
+)
+
+// defaultEventBufferSize bounds how many events a consumer channel can fall
+// behind by before we start dropping for it, rather than blocking the swarm,
+// when the cluster configuration leaves it unset.
+const defaultEventBufferSize = 64
+
+// PeerEventType identifies the kind of cluster-membership change a PeerEvent
+// carries.
+type PeerEventType int
+
+// The set of peer events a consumer can observe via Swarm.PeerEvents.
+const (
+	PeerJoin PeerEventType = iota
+	PeerLeave
+)
+
+// PeerEvent describes a peer joining or leaving the cluster.
+type PeerEvent struct {
+	Type PeerEventType
+	Peer mesh.PeerName
+}
+
+// SubEventType identifies the kind of subscription change a SubEvent
+// carries.
+type SubEventType int
+
+// The set of subscription events a consumer can observe via
+// Swarm.SubscriptionEvents.
+const (
+	SubAdded SubEventType = iota
+	SubRemoved
+)
+
+// SubEvent describes a subscription being added or removed on behalf of a
+// peer.
+type SubEvent struct {
+	Type SubEventType
+	Ssid subscription.Ssid
+	Peer mesh.PeerName
+}
+
+// eventBroker multiplexes internal peer and subscription occurrences out to
+// any number of registered consumer channels, so the broker service, the
+// metrics exporter and external bridges can each observe cluster topology
+// independently instead of all being forced through a single callback field.
+type eventBroker struct {
+	sync.Mutex
+	peers      map[chan PeerEvent]bool
+	subs       map[chan SubEvent]subscription.Ssid // Keyed by the consumer's SSID prefix filter.
+	bufferSize int
+}
+
+// newEventBroker creates an empty broker whose consumer channels are each
+// buffered to bufferSize, falling back to defaultEventBufferSize when it's
+// left unset.
+func newEventBroker(bufferSize int) *eventBroker {
+	if bufferSize <= 0 {
+		bufferSize = defaultEventBufferSize
+	}
+	return &eventBroker{
+		peers:      make(map[chan PeerEvent]bool),
+		subs:       make(map[chan SubEvent]subscription.Ssid),
+		bufferSize: bufferSize,
+	}
+}
+
+// PeerEvents registers a new consumer channel for peer join/leave events.
+func (b *eventBroker) PeerEvents() <-chan PeerEvent {
+	ch := make(chan PeerEvent, b.bufferSize)
+	b.Lock()
+	b.peers[ch] = true
+	b.Unlock()
+	return ch
+}
+
+// SubscriptionEvents registers a new consumer channel for subscription
+// events, optionally filtered to SSIDs sharing the given prefix. An empty
+// prefix matches everything.
+func (b *eventBroker) SubscriptionEvents(prefix subscription.Ssid) <-chan SubEvent {
+	ch := make(chan SubEvent, b.bufferSize)
+	b.Lock()
+	b.subs[ch] = prefix
+	b.Unlock()
+	return ch
+}
+
+// ClosePeerEvents unregisters and closes a channel previously returned by
+// PeerEvents, so a caller that resubscribes doesn't leak an entry in peers
+// for the life of the process. It's a no-op if ch is unknown.
+func (b *eventBroker) ClosePeerEvents(ch <-chan PeerEvent) {
+	b.Lock()
+	defer b.Unlock()
+	for c := range b.peers {
+		if (<-chan PeerEvent)(c) == ch {
+			delete(b.peers, c)
+			close(c)
+			return
+		}
+	}
+}
+
+// CloseSubscriptionEvents unregisters and closes a channel previously
+// returned by SubscriptionEvents. It's a no-op if ch is unknown.
+func (b *eventBroker) CloseSubscriptionEvents(ch <-chan SubEvent) {
+	b.Lock()
+	defer b.Unlock()
+	for c := range b.subs {
+		if (<-chan SubEvent)(c) == ch {
+			delete(b.subs, c)
+			close(c)
+			return
+		}
+	}
+}
+
+// emitPeer fans a peer event out to every registered consumer, dropping it
+// for any consumer whose buffer is currently full rather than blocking.
+func (b *eventBroker) emitPeer(ev PeerEvent) {
+	b.Lock()
+	defer b.Unlock()
+	for ch := range b.peers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// emitSub fans a subscription event out to every registered consumer whose
+// filter matches, dropping it for any consumer whose buffer is full.
+func (b *eventBroker) emitSub(ev SubEvent) {
+	b.Lock()
+	defer b.Unlock()
+	for ch, prefix := range b.subs {
+		if !hasPrefix(ev.Ssid, prefix) {
+			continue
+		}
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// hasPrefix reports whether ssid starts with prefix. An empty prefix matches
+// any ssid.
+func hasPrefix(ssid, prefix subscription.Ssid) bool {
+	if len(prefix) > len(ssid) {
+		return false
+	}
+	for i, p := range prefix {
+		if ssid[i] != p {
+			return false
+		}
+	}
+	return true
+}
+
+// PeerEvents returns a channel of PeerJoin/PeerLeave events as peers come
+// and go from the cluster.
+func (s *Swarm) PeerEvents() <-chan PeerEvent {
+	return s.events.PeerEvents()
+}
+
+// SubscriptionEvents returns a channel of SubAdded/SubRemoved events for
+// subscriptions whose SSID shares the given prefix. Pass a nil or empty ssid
+// to observe every subscription change in the cluster.
+func (s *Swarm) SubscriptionEvents(ssid subscription.Ssid) <-chan SubEvent {
+	return s.events.SubscriptionEvents(ssid)
+}
+
+// ClosePeerEvents unregisters a channel previously returned by PeerEvents.
+// Callers that resubscribe, such as reconnect loops, should call this for
+// the channel they're replacing to avoid leaking an entry for the life of
+// the process.
+func (s *Swarm) ClosePeerEvents(ch <-chan PeerEvent) {
+	s.events.ClosePeerEvents(ch)
+}
+
+// CloseSubscriptionEvents unregisters a channel previously returned by
+// SubscriptionEvents.
+func (s *Swarm) CloseSubscriptionEvents(ch <-chan SubEvent) {
+	s.events.CloseSubscriptionEvents(ch)
+}
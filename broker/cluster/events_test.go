@@ -0,0 +1,88 @@
+/**********************************************************************************
+* Copyright (c) 2009-2017 Misakai Ltd.
+* This program is free software: you can redistribute it and/or modify it under the
+* terms of the GNU Affero General Public License as published by the  Free Software
+* Foundation, either version 3 of the License, or(at your option) any later version.
+*
+* This program is distributed  in the hope that it  will be useful, but WITHOUT ANY
+* WARRANTY;  without even  the implied warranty of MERCHANTABILITY or FITNESS FOR A
+* PARTICULAR PURPOSE.  See the GNU Affero General Public License  for  more details.
+*
+* You should have  received a copy  of the  GNU Affero General Public License along
+* with this program. If not, see<http://www.gnu.org/licenses/>.
+************************************************************************************/
+
+package cluster
+
+import (
+	"testing"
+
+	"github.com/emitter-io/emitter/broker/subscription"
+	"github.com/stretchr/testify/assert"
+	"github.com/weaveworks/mesh"
+)
+
+func TestEventBroker_PeerEventsFanOut(t *testing.T) {
+	b := newEventBroker(0)
+	a := b.PeerEvents()
+	c := b.PeerEvents()
+
+	b.emitPeer(PeerEvent{Type: PeerJoin, Peer: 1})
+
+	assert.Equal(t, PeerEvent{Type: PeerJoin, Peer: 1}, <-a)
+	assert.Equal(t, PeerEvent{Type: PeerJoin, Peer: 1}, <-c)
+}
+
+func TestEventBroker_SubscriptionEventsPrefixFilter(t *testing.T) {
+	b := newEventBroker(0)
+	matching := b.SubscriptionEvents(subscription.Ssid{1, 2})
+	other := b.SubscriptionEvents(subscription.Ssid{9})
+
+	b.emitSub(SubEvent{Type: SubAdded, Ssid: subscription.Ssid{1, 2, 3}})
+
+	assert.Equal(t, SubAdded, (<-matching).Type)
+	select {
+	case <-other:
+		t.Fatal("expected non-matching consumer to receive nothing")
+	default:
+	}
+}
+
+func TestEventBroker_DropsForFullConsumer(t *testing.T) {
+	b := newEventBroker(0)
+	ch := b.PeerEvents()
+
+	for i := 0; i < defaultEventBufferSize+10; i++ {
+		b.emitPeer(PeerEvent{Type: PeerJoin, Peer: mesh.PeerName(i)})
+	}
+
+	assert.LessOrEqual(t, len(ch), defaultEventBufferSize)
+}
+
+func TestEventBroker_ConfigurableBufferSize(t *testing.T) {
+	b := newEventBroker(4)
+	ch := b.PeerEvents()
+	assert.Equal(t, 4, cap(ch))
+}
+
+func TestEventBroker_ClosePeerEvents(t *testing.T) {
+	b := newEventBroker(0)
+	ch := b.PeerEvents()
+
+	b.ClosePeerEvents(ch)
+	assert.Len(t, b.peers, 0)
+
+	_, open := <-ch
+	assert.False(t, open, "channel should be closed after unregistering")
+}
+
+func TestEventBroker_CloseSubscriptionEvents(t *testing.T) {
+	b := newEventBroker(0)
+	ch := b.SubscriptionEvents(nil)
+
+	b.CloseSubscriptionEvents(ch)
+	assert.Len(t, b.subs, 0)
+
+	_, open := <-ch
+	assert.False(t, open, "channel should be closed after unregistering")
+}
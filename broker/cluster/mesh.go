@@ -0,0 +1,183 @@
+/**********************************************************************************
+* Copyright (c) 2009-2017 Misakai Ltd.
+* This program is free software: you can redistribute it and/or modify it under the
+* terms of the GNU Affero General Public License as published by the  Free Software
+* Foundation, either version 3 of the License, or(at your option) any later version.
+*
+* This program is distributed  in the hope that it  will be useful, but WITHOUT ANY
+* WARRANTY;  without even  the implied warranty of MERCHANTABILITY or FITNESS FOR A
+* PARTICULAR PURPOSE.  See the GNU Affero General Public License  for  more details.
+*
+* You should have  received a copy  of the  GNU Affero General Public License along
+* with this program. If not, see<http://www.gnu.org/licenses/>.
+************************************************************************************/
+
+package cluster
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/emitter-io/emitter/logging"
+	"github.com/weaveworks/mesh"
+)
+
+// Default bounds for the eager-push overlay, used whenever the cluster
+// configuration leaves them unset.
+const (
+	defaultMeshD   = 6
+	defaultMeshDlo = 4
+	defaultMeshDhi = 12
+)
+
+// meshOverlay maintains the bounded-degree set of "eager" peers that receive
+// message frames and subscription deltas directly. Peers outside of the mesh
+// are "lazy": they're only told what we've seen via periodic IHAVE messages
+// and pull the bodies they're missing with IWANT. This keeps fanout bounded
+// as the cluster grows, instead of reinforcing a full mesh between every pair
+// of nodes.
+type meshOverlay struct {
+	sync.Mutex
+	peers map[mesh.PeerName]bool
+}
+
+func newMeshOverlay() *meshOverlay {
+	return &meshOverlay{
+		peers: make(map[mesh.PeerName]bool),
+	}
+}
+
+// InMesh returns whether a peer is currently one of our eager peers.
+func (m *meshOverlay) InMesh(name mesh.PeerName) bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.peers[name]
+}
+
+// Size returns the current number of eager peers.
+func (m *meshOverlay) Size() int {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.peers)
+}
+
+// Members returns a snapshot of the current eager peers.
+func (m *meshOverlay) Members() []mesh.PeerName {
+	m.Lock()
+	defer m.Unlock()
+	names := make([]mesh.PeerName, 0, len(m.peers))
+	for name := range m.peers {
+		names = append(names, name)
+	}
+	return names
+}
+
+// graft adds a peer to the eager mesh.
+func (m *meshOverlay) graft(name mesh.PeerName) {
+	m.Lock()
+	m.peers[name] = true
+	m.Unlock()
+}
+
+// prune removes a peer from the eager mesh, demoting it back to lazy.
+func (m *meshOverlay) prune(name mesh.PeerName) {
+	m.Lock()
+	delete(m.peers, name)
+	m.Unlock()
+}
+
+// meshBounds returns the configured (d, dlo, dhi) degree bounds, falling back
+// to the package defaults when the cluster configuration leaves them unset.
+func (s *Swarm) meshBounds() (d, dlo, dhi int) {
+	d, dlo, dhi = defaultMeshD, defaultMeshDlo, defaultMeshDhi
+	if s.config.MeshD > 0 {
+		d = s.config.MeshD
+	}
+	if s.config.MeshDlo > 0 {
+		dlo = s.config.MeshDlo
+	}
+	if s.config.MeshDhi > 0 {
+		dhi = s.config.MeshDhi
+	}
+	return
+}
+
+// peerNames returns the names of all known, non-self peers in random order.
+func (s *Swarm) peerNames() []mesh.PeerName {
+	desc := s.router.Peers.Descriptions()
+	names := make([]mesh.PeerName, 0, len(desc))
+	for _, d := range desc {
+		if !d.Self {
+			names = append(names, d.Name)
+		}
+	}
+
+	rand.Shuffle(len(names), func(i, j int) {
+		names[i], names[j] = names[j], names[i]
+	})
+	return names
+}
+
+// meshHeartbeat performs one round of mesh maintenance: grafting peers in
+// when we're below Dlo, pruning randomly chosen eager peers when we're above
+// Dhi, and advertising what we've recently seen to our lazy peers so they can
+// pull anything they're missing. A GRAFT/PRUNE envelope is sent to the
+// affected peer in each case, so the other side's onControl adds or drops us
+// from its own eager mesh too, rather than the two sides drifting apart on
+// which links are supposedly eager. It's called periodically by Swarm.Listen.
+func (s *Swarm) meshHeartbeat() {
+	d, dlo, dhi := s.meshBounds()
+	candidates := s.peerNames()
+
+	switch size := s.mesh.Size(); {
+	case size < dlo:
+		for _, name := range candidates {
+			if s.mesh.InMesh(name) || s.mesh.Size() >= d {
+				continue
+			}
+			s.mesh.graft(name)
+			s.gossip.GossipUnicast(name, control{op: opGraft}.Encode())
+			logging.LogTarget("mesh", "graft", name)
+		}
+	case size > dhi:
+		excess := size - d
+		for _, name := range candidates {
+			if excess <= 0 {
+				break
+			}
+			if !s.mesh.InMesh(name) {
+				continue
+			}
+			s.mesh.prune(name)
+			s.gossip.GossipUnicast(name, control{op: opPrune}.Encode())
+			logging.LogTarget("mesh", "prune", name)
+			excess--
+		}
+	}
+
+	s.advertise(candidates)
+}
+
+// advertise sends an IHAVE listing our recently seen message IDs to every
+// peer that is not currently in our eager mesh.
+func (s *Swarm) advertise(candidates []mesh.PeerName) {
+	ihave := control{op: opIHave, ids: s.cache.RecentIDs()}.Encode()
+	for _, name := range candidates {
+		if !s.mesh.InMesh(name) {
+			s.gossip.GossipUnicast(name, ihave)
+		}
+	}
+}
+
+// pushToMesh sends a gossip payload directly to every peer in our eager mesh,
+// bypassing the underlying library's full-neighbor broadcast. The payload is
+// prefixed with opState, same as the syncer paths, so OnGossipUnicast routes
+// it into merge().
+func (s *Swarm) pushToMesh(data mesh.GossipData) {
+	for _, buf := range data.Encode() {
+		msg := append([]byte{opState}, buf...)
+		for _, name := range s.mesh.Members() {
+			s.gossip.GossipUnicast(name, msg)
+		}
+	}
+}
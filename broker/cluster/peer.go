@@ -0,0 +1,120 @@
+/**********************************************************************************
+* Copyright (c) 2009-2017 Misakai Ltd.
+* This program is free software: you can redistribute it and/or modify it under the
+* terms of the GNU Affero General Public License as published by the  Free Software
+* Foundation, either version 3 of the License, or(at your option) any later version.
+*
+* This program is distributed  in the hope that it  will be useful, but WITHOUT ANY
+* WARRANTY;  without even  the implied warranty of MERCHANTABILITY or FITNESS FOR A
+* PARTICULAR PURPOSE.  See the GNU Affero General Public License  for  more details.
+*
+* You should have  received a copy  of the  GNU Affero General Public License along
+* with this program. If not, see<http://www.gnu.org/licenses/>.
+************************************************************************************/
+
+package cluster
+
+import (
+	"crypto/ed25519"
+	"sync"
+	"time"
+
+	"github.com/emitter-io/emitter/broker/subscription"
+	"github.com/weaveworks/mesh"
+)
+
+// subscriptionEntry tracks one active subscription we've accepted on behalf
+// of a remote peer.
+type subscriptionEntry struct {
+	Ssid subscription.Ssid
+	Conn string
+}
+
+// subscriptionSet is the set of subscription event keys currently attributed
+// to a peer.
+type subscriptionSet map[string]subscriptionEntry
+
+// All returns every subscription currently in the set.
+func (s subscriptionSet) All() []subscriptionEntry {
+	all := make([]subscriptionEntry, 0, len(s))
+	for _, e := range s {
+		all = append(all, e)
+	}
+	return all
+}
+
+// Peer represents a remote node in the cluster, as seen from our side of the
+// gossip protocol.
+type Peer struct {
+	sync.Mutex
+	name     mesh.PeerName
+	swarm    *Swarm
+	subs     subscriptionSet
+	activity time.Time
+
+	// PubKey is the peer's advertised Ed25519 public key, used to verify
+	// subscription events attributed to it; message frames are not verified.
+	// It's empty until the peer's signed hello has been observed.
+	PubKey ed25519.PublicKey
+}
+
+// newPeer creates a new peer descriptor owned by the swarm.
+func (s *Swarm) newPeer(name mesh.PeerName) *Peer {
+	return &Peer{
+		name:     name,
+		swarm:    s,
+		subs:     make(subscriptionSet),
+		activity: time.Now(),
+	}
+}
+
+// PublicKey returns the peer's advertised Ed25519 public key, or nil if its
+// hello hasn't been observed yet.
+func (p *Peer) PublicKey() ed25519.PublicKey {
+	p.Lock()
+	defer p.Unlock()
+	return p.PubKey
+}
+
+// touch marks the peer as recently active.
+func (p *Peer) touch() {
+	p.Lock()
+	p.activity = time.Now()
+	p.Unlock()
+}
+
+// IsActive returns whether the peer has been active recently.
+func (p *Peer) IsActive() bool {
+	p.Lock()
+	defer p.Unlock()
+	return time.Since(p.activity) < 30*time.Second
+}
+
+// onSubscribe records a subscription event key as belonging to this peer. It
+// returns true the first time a given key is seen.
+func (p *Peer) onSubscribe(key string, ssid subscription.Ssid) bool {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.subs[key]; ok {
+		return false
+	}
+	p.subs[key] = subscriptionEntry{Ssid: ssid}
+	return true
+}
+
+// onUnsubscribe removes a subscription event key previously attributed to
+// this peer. It returns true if the key was known.
+func (p *Peer) onUnsubscribe(key string, ssid subscription.Ssid) bool {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.subs[key]; !ok {
+		return false
+	}
+	delete(p.subs, key)
+	return true
+}
+
+// Close releases any resources held for this peer.
+func (p *Peer) Close() error {
+	return nil
+}
@@ -0,0 +1,167 @@
+/**********************************************************************************
+* Copyright (c) 2009-2017 Misakai Ltd.
+* This program is free software: you can redistribute it and/or modify it under the
+* terms of the GNU Affero General Public License as published by the  Free Software
+* Foundation, either version 3 of the License, or(at your option) any later version.
+*
+* This program is distributed  in the hope that it  will be useful, but WITHOUT ANY
+* WARRANTY;  without even  the implied warranty of MERCHANTABILITY or FITNESS FOR A
+* PARTICULAR PURPOSE.  See the GNU Affero General Public License  for  more details.
+*
+* You should have  received a copy  of the  GNU Affero General Public License along
+* with this program. If not, see<http://www.gnu.org/licenses/>.
+************************************************************************************/
+
+package cluster
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/gob"
+	"strings"
+	"sync"
+
+	"github.com/emitter-io/emitter/broker/subscription"
+	"github.com/emitter-io/emitter/security"
+	"github.com/weaveworks/mesh"
+)
+
+// SubscriptionEvent represents a single subscribe or unsubscribe attributed
+// to a peer.
+type SubscriptionEvent struct {
+	Peer      mesh.PeerName
+	Conn      security.ID
+	Ssid      subscription.Ssid
+	Signature []byte // The Ed25519 signature of Peer/Conn/Ssid, set by Sign.
+}
+
+// payload returns the fields that get signed, deliberately excluding the
+// signature itself.
+func (e *SubscriptionEvent) payload() []byte {
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(struct {
+		Peer mesh.PeerName
+		Conn security.ID
+		Ssid subscription.Ssid
+	}{e.Peer, e.Conn, e.Ssid})
+	return buf.Bytes()
+}
+
+// Sign attaches a signature over the event's payload, computed with key.
+func (e *SubscriptionEvent) Sign(key ed25519.PrivateKey) {
+	e.Signature = ed25519.Sign(key, e.payload())
+}
+
+// Verify reports whether the event carries a valid signature for pub.
+func (e *SubscriptionEvent) Verify(pub ed25519.PublicKey) bool {
+	return len(pub) > 0 && len(e.Signature) > 0 && ed25519.Verify(pub, e.payload(), e.Signature)
+}
+
+// Encode serialises the event, including its signature, to a stable string
+// usable as a subscriptionState key.
+func (e *SubscriptionEvent) Encode() string {
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(e)
+	return buf.String()
+}
+
+// decodeSubscriptionEvent parses a subscriptionState key back into an event.
+func decodeSubscriptionEvent(key string) (*SubscriptionEvent, error) {
+	var e SubscriptionEvent
+	if err := gob.NewDecoder(strings.NewReader(key)).Decode(&e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// eventState tracks whether an encoded event key represents an add or a
+// remove.
+type eventState struct {
+	Added   bool
+	Removed bool
+}
+
+// IsAdded returns whether this entry represents an active subscription.
+func (e eventState) IsAdded() bool {
+	return e.Added && !e.Removed
+}
+
+// IsRemoved returns whether this entry represents an unsubscription.
+func (e eventState) IsRemoved() bool {
+	return e.Removed
+}
+
+// subscriptionState is the full set of subscription events known to this
+// node. It implements mesh.GossipData so it can be exchanged wholesale (the
+// syncer's full-state unicasts) or as a delta (pushToMesh).
+type subscriptionState struct {
+	sync.Mutex
+	items map[string]eventState
+}
+
+// newSubscriptionState creates an empty state.
+func newSubscriptionState() *subscriptionState {
+	return &subscriptionState{items: make(map[string]eventState)}
+}
+
+// Add marks an encoded event key as an active subscription.
+func (s *subscriptionState) Add(key string) {
+	s.Lock()
+	defer s.Unlock()
+	s.items[key] = eventState{Added: true}
+}
+
+// Remove marks an encoded event key as removed.
+func (s *subscriptionState) Remove(key string) {
+	s.Lock()
+	defer s.Unlock()
+	s.items[key] = eventState{Removed: true}
+}
+
+// All returns a snapshot of every entry in the state.
+func (s *subscriptionState) All() map[string]eventState {
+	s.Lock()
+	defer s.Unlock()
+	all := make(map[string]eventState, len(s.items))
+	for k, v := range s.items {
+		all[k] = v
+	}
+	return all
+}
+
+// Encode implements mesh.GossipData.
+func (s *subscriptionState) Encode() [][]byte {
+	s.Lock()
+	defer s.Unlock()
+
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(s.items)
+	return [][]byte{buf.Bytes()}
+}
+
+// decodeSubscriptionState decodes a full or delta state received over the
+// wire.
+func decodeSubscriptionState(buf []byte) (*subscriptionState, error) {
+	items := make(map[string]eventState)
+	if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(&items); err != nil {
+		return nil, err
+	}
+	return &subscriptionState{items: items}, nil
+}
+
+// Merge implements mesh.GossipData. It folds other into this state in place
+// and returns a delta containing only the entries that were new to us.
+func (s *subscriptionState) Merge(other mesh.GossipData) mesh.GossipData {
+	o := other.(*subscriptionState)
+	delta := newSubscriptionState()
+
+	s.Lock()
+	defer s.Unlock()
+	for k, v := range o.All() {
+		if existing, ok := s.items[k]; !ok || existing != v {
+			s.items[k] = v
+			delta.items[k] = v
+		}
+	}
+	return delta
+}
@@ -15,9 +15,13 @@
 package cluster
 
 import (
+	"crypto/ed25519"
+	"fmt"
+	"io"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/emitter-io/emitter/broker/message"
@@ -33,15 +37,30 @@ import (
 // Swarm represents a gossiper.
 type Swarm struct {
 	sync.Mutex
-	name    mesh.PeerName         // The name of ourselves.
-	actions chan func()           // The action queue for the peer.
-	closing chan bool             // The closing channel.
-	config  *config.ClusterConfig // The configuration for the cluster.
-	state   *subscriptionState    // The state to synchronise.
-	router  *mesh.Router          // The mesh router.
-	gossip  mesh.Gossip           // The gossip protocol.
-	members *sync.Map             // The map of members in the peer set.
-
+	name       mesh.PeerName         // The name of ourselves.
+	actions    chan func()           // The action queue for the peer.
+	closing    chan bool             // The closing channel.
+	config     *config.ClusterConfig // The configuration for the cluster.
+	state      *subscriptionState    // The state to synchronise.
+	router     *mesh.Router          // The mesh router.
+	gossip     mesh.Gossip           // The gossip protocol.
+	members    *sync.Map             // The map of members in the peer set.
+	mesh       *meshOverlay          // The bounded-degree set of eager peers.
+	cache      *MessageCache         // The sliding-window cache of recently seen message frames.
+	key        ed25519.PrivateKey    // Our Ed25519 identity, used to sign subscription events and our hello; message frames are not signed.
+	syncer     *syncManager          // The active/passive syncer rotation.
+	discovery  Discovery             // The configured peer discovery provider, if any.
+	discovered *sync.Map             // The set of addresses last returned by discovery.
+	events     *eventBroker          // Multiplexes peer/subscription events to any number of consumers.
+
+	antiEntropyPulls   uint64 // Number of anti-entropy rounds initiated, accessed atomically.
+	antiEntropySent    uint64 // Number of events sent in response to a digest want, accessed atomically.
+	antiEntropyLearned uint64 // Number of events learned from a peer's anti-entropy reply, accessed atomically.
+
+	// OnSubscribe, OnUnsubscribe and OnMessage remain as a thin adapter for
+	// existing consumers that expect a single callback. New consumers should
+	// prefer PeerEvents/SubscriptionEvents, which support any number of
+	// independent observers instead of forcing everything through one.
 	OnSubscribe   func(subscription.Ssid, subscription.Subscriber) bool // Delegate to invoke when the subscription event is received.
 	OnUnsubscribe func(subscription.Ssid, subscription.Subscriber) bool // Delegate to invoke when the subscription event is received.
 	OnMessage     func(*message.Message)                                // Delegate to invoke when a new message is received.
@@ -52,13 +71,30 @@ var _ mesh.Gossiper = &Swarm{}
 
 // NewSwarm creates a new swarm messaging layer.
 func NewSwarm(cfg *config.ClusterConfig, closing chan bool) *Swarm {
+	key, err := loadClusterKey(cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	discovery, err := NewDiscovery(cfg)
+	if err != nil {
+		panic(err)
+	}
+
 	swarm := &Swarm{
-		name:    getLocalPeerName(cfg),
-		actions: make(chan func()),
-		closing: closing,
-		config:  cfg,
-		state:   newSubscriptionState(),
-		members: new(sync.Map),
+		name:       getLocalPeerName(cfg),
+		actions:    make(chan func()),
+		closing:    closing,
+		config:     cfg,
+		state:      newSubscriptionState(),
+		members:    new(sync.Map),
+		mesh:       newMeshOverlay(),
+		cache:      NewMessageCache(defaultCacheWindows, defaultCacheGossip),
+		key:        key,
+		syncer:     newSyncManager(cfg.NumActiveSyncers),
+		discovery:  discovery,
+		discovered: new(sync.Map),
+		events:     newEventBroker(cfg.EventBufferSize),
 	}
 
 	// Get the cluster binding address
@@ -112,7 +148,10 @@ func (s *Swarm) onPeerOffline(name mesh.PeerName) {
 		// Unsubscribe from all active subscriptions
 		for _, c := range peer.subs.All() {
 			s.OnUnsubscribe(c.Ssid, peer)
+			s.events.emitSub(SubEvent{Type: SubRemoved, Ssid: c.Ssid, Peer: peer.name})
 		}
+
+		s.events.emitPeer(PeerEvent{Type: PeerLeave, Peer: name})
 	}
 }
 
@@ -127,6 +166,14 @@ func (s *Swarm) FindPeer(name mesh.PeerName) *Peer {
 	v, ok := s.members.LoadOrStore(name, peer)
 	if !ok {
 		logging.LogTarget("swarm", "peer created", peer.name)
+
+		// Introduce ourselves to the newly observed peer with a signed hello,
+		// so it can learn our public key and verify events we originate.
+		pub := s.key.Public().(ed25519.PublicKey)
+		hello := append([]byte{opHello}, s.sign(append([]byte{}, pub...))...)
+		s.gossip.GossipUnicast(name, hello)
+
+		s.events.emitPeer(PeerEvent{Type: PeerJoin, Peer: name})
 	}
 	return v.(*Peer)
 }
@@ -143,24 +190,74 @@ func (s *Swarm) Listen() {
 	// initiating connections with all of our peers.
 	utils.Repeat(s.update, 5*time.Second, s.closing)
 
+	// If the configured discovery provider can push an immediate change
+	// notification (file discovery via fsnotify), react to it right away
+	// instead of waiting for update's next tick.
+	if w, ok := s.discovery.(watchableDiscovery); ok {
+		go s.watchDiscovery(w)
+	}
+
+	// Maintain our bounded-degree eager mesh and advertise to lazy peers.
+	heartbeat := s.config.MeshHeartbeat
+	if heartbeat <= 0 {
+		heartbeat = 1 * time.Second
+	}
+	utils.Repeat(s.meshHeartbeat, heartbeat, s.closing)
+
+	// Rotate the message cache window once per heartbeat, so it only ever
+	// holds a bounded amount of recent history.
+	utils.Repeat(s.cache.Shift, heartbeat, s.closing)
+
+	// Periodically rotate which peers are active syncers, and occasionally
+	// force a from-scratch state exchange to repair silent divergence.
+	rotateInterval := s.config.SyncerRotateInterval
+	if rotateInterval <= 0 {
+		rotateInterval = defaultSyncerRotateInterval
+	}
+	utils.Repeat(s.syncerRotate, rotateInterval, s.closing)
+
+	historicalInterval := s.config.HistoricalSyncInterval
+	if historicalInterval <= 0 {
+		historicalInterval = defaultHistoricalSyncInterval
+	}
+	utils.Repeat(s.syncerHistorical, historicalInterval, s.closing)
+
+	// Periodically reconcile with a random neighbor via pull-based
+	// anti-entropy, to self-heal from a dropped GossipBroadcast or a
+	// transient partition.
+	antiEntropyInterval := s.config.AntiEntropyInterval
+	if antiEntropyInterval <= 0 {
+		antiEntropyInterval = defaultAntiEntropyInterval
+	}
+	utils.Repeat(s.antiEntropyPull, antiEntropyInterval, s.closing)
+
 	// Start the router
 	s.router.Start()
 }
 
-// update attempt to update our cluster structure by initiating connections
-// with all of our peers. This is is called periodically.
+// update attempts to keep a direct connection open to each of our current
+// eager mesh peers. This is called periodically.
 func (s *Swarm) update() {
-	desc := s.router.Peers.Descriptions()
-	for _, peer := range desc {
+	// Resolve peer addresses through the configured discovery provider, for
+	// deployments where pod IPs change and there's no stable bootstrap peer.
+	s.discover()
+
+	nicknames := make(map[mesh.PeerName]string)
+	for _, peer := range s.router.Peers.Descriptions() {
 		if !peer.Self {
 			// Mark the peer as active, so even if there's no messages being exchanged
 			// we still keep the peer, since we know that the peer is live.
 			s.FindPeer(peer.Name).touch()
+			nicknames[peer.Name] = peer.NickName
+		}
+	}
 
-			// reinforce structure
-			if peer.NumConnections < (len(desc) - 1) {
-				s.Join(peer.NickName)
-			}
+	// Only reinforce connections towards our eager mesh, rather than towards
+	// every known peer. A full mesh of direct connections is exactly what the
+	// bounded-degree overlay exists to avoid as the cluster grows.
+	for _, name := range s.mesh.Members() {
+		if nick, ok := nicknames[name]; ok {
+			s.Join(nick)
 		}
 	}
 
@@ -178,7 +275,11 @@ func (s *Swarm) Join(peers ...string) []error {
 	return s.router.ConnectionMaker.InitiateConnections(peers, false)
 }
 
-// Merge merges the incoming state and returns a delta
+// Merge merges the incoming state and returns a delta. Only events whose
+// signature verifies against the peer's advertised public key are folded
+// into our shared state or handed back in the delta; an unverifiable event
+// is dropped outright, rather than merged and then merely skipped for local
+// notification, so it's never retained in s.state or re-gossiped further.
 func (s *Swarm) merge(buf []byte) (mesh.GossipData, error) {
 
 	// Decode the state we just received
@@ -187,27 +288,57 @@ func (s *Swarm) merge(buf []byte) (mesh.GossipData, error) {
 		return nil, err
 	}
 
-	// Merge and get the delta
-	delta := s.state.Merge(other)
+	// Verify every event before any of it reaches our shared state.
+	verified := newSubscriptionState()
 	for k, v := range other.All() {
 
 		// Decode the event
 		ev, err := decodeSubscriptionEvent(k)
 		if err != nil {
-			return nil, err
+			logging.LogError("swarm", "decode subscription event", err)
+			continue
 		}
 
 		// Get the peer to use
 		peer := s.FindPeer(ev.Peer)
 
-		// If the subscription is added, notify (TODO: use channels)
+		// Reject any event that doesn't carry a valid signature for the peer
+		// it's attributed to, so cluster membership alone can't be used to
+		// forge subscriptions on someone else's behalf.
+		if !ev.Verify(peer.PublicKey()) {
+			logging.LogTarget("swarm", "dropped unverified subscription event", ev.Peer)
+			continue
+		}
+
+		switch {
+		case v.IsAdded():
+			verified.Add(k)
+		case v.IsRemoved():
+			verified.Remove(k)
+		}
+	}
+
+	// Merge and get the delta
+	delta := s.state.Merge(verified)
+	for k, v := range verified.All() {
+		ev, err := decodeSubscriptionEvent(k)
+		if err != nil {
+			return nil, err
+		}
+		peer := s.FindPeer(ev.Peer)
+
+		// If the subscription is added, notify. OnSubscribe remains as a thin
+		// adapter for existing consumers; SubscriptionEvents is the preferred
+		// way to observe this without patching Swarm itself.
 		if v.IsAdded() && peer.onSubscribe(k, ev.Ssid) {
 			s.OnSubscribe(ev.Ssid, peer)
+			s.events.emitSub(SubEvent{Type: SubAdded, Ssid: ev.Ssid, Peer: ev.Peer})
 		}
 
-		// If the subscription is removed, notify (TODO: use channels)
+		// If the subscription is removed, notify.
 		if v.IsRemoved() && peer.onUnsubscribe(k, ev.Ssid) {
 			s.OnUnsubscribe(ev.Ssid, peer)
+			s.events.emitSub(SubEvent{Type: SubRemoved, Ssid: ev.Ssid, Peer: ev.Peer})
 		}
 	}
 
@@ -224,9 +355,15 @@ func (s *Swarm) NumPeers() int {
 	return 0
 }
 
-// Gossip returns the state of everything we know; gets called periodically.
+// Gossip is called periodically by the underlying mesh library to obtain the
+// "complete" state to advertise to every neighbor. We already push the full
+// subscriptionState explicitly to our active syncers (see syncerRotate), so
+// returning it here too would ship it to every neighbor on every tick,
+// reintroducing the O(N^2) traffic the active/passive split exists to avoid.
+// Return an empty state; eager mesh peers keep receiving deltas via
+// pushToMesh regardless.
 func (s *Swarm) Gossip() (complete mesh.GossipData) {
-	return s.state
+	return newSubscriptionState()
 }
 
 // OnGossip merges received data into state and returns "everything new I've just
@@ -251,25 +388,161 @@ func (s *Swarm) OnGossipBroadcast(src mesh.PeerName, buf []byte) (delta mesh.Gos
 	return
 }
 
-// OnGossipUnicast occurs when the gossip unicast is received. In emitter this is
-// used only to forward message frames around.
+// OnGossipUnicast occurs when the gossip unicast is received. In emitter this
+// carries a mesh control message (GRAFT/PRUNE/IHAVE/IWANT), a hello, a state
+// or anti-entropy exchange, or a forwarded message frame — every case is
+// tagged with an explicit leading opcode, so there's no payload on this
+// channel left to disambiguate by falling through to a decoder and seeing
+// what sticks.
 func (s *Swarm) OnGossipUnicast(src mesh.PeerName, buf []byte) (err error) {
+	if len(buf) == 0 {
+		return nil
+	}
 
-	// Decode an incoming message frame
-	frame, err := message.DecodeFrame(buf)
+	switch buf[0] {
+	case opHello:
+		s.onHello(src, buf[1:])
+		return nil
+
+	case opState:
+		if _, err := s.merge(buf[1:]); err != nil {
+			logging.LogError("swarm", "merge state sync", err)
+		}
+		return nil
+
+	case opAntiEntropyData:
+		delta, err := s.merge(buf[1:])
+		if err != nil {
+			logging.LogError("swarm", "merge anti-entropy reply", err)
+			return nil
+		}
+		if learned, ok := delta.(*subscriptionState); ok {
+			atomic.AddUint64(&s.antiEntropyLearned, uint64(len(learned.All())))
+		}
+		return nil
+
+	case opDigestPull:
+		s.onDigestPull(src, decodeDigest(buf[1:]))
+		return nil
+
+	case opDigestWant:
+		s.onDigestWant(src, decodeDigest(buf[1:]))
+		return nil
+
+	case opGraft, opPrune, opIHave, opIWant:
+		if ctrl, ok := decodeControl(buf); ok {
+			s.onControl(src, ctrl)
+		}
+		return nil
+
+	case opFrame:
+		return s.onFrame(src, buf)
+	}
+
+	err = fmt.Errorf("swarm: unrecognised gossip unicast opcode %d", buf[0])
+	logging.LogError("swarm", "gossip unicast", err)
+	return err
+}
+
+// onFrame decodes a forwarded message.Frame (opFrame-prefixed), dedupes its
+// messages against our cache, and relays it onward through the eager mesh if
+// any of it was new.
+func (s *Swarm) onFrame(src mesh.PeerName, buf []byte) error {
+	frame, err := message.DecodeFrame(buf[1:])
 	if err != nil {
 		logging.LogError("swarm", "decode frame", err)
 		return err
 	}
 
-	// Go through each message in the decoded frame
-	for _, m := range frame {
-		s.OnMessage(&m)
+	// Go through each message in the decoded frame, dropping anything we've
+	// already seen rather than relying on the connection it arrived on.
+	fresh := false
+	for i := range frame {
+		m := &frame[i]
+		if _, isNew := s.cache.PutIfAbsent(m); isNew {
+			fresh = true
+			s.OnMessage(m)
+		}
+	}
+
+	// Relay the frame onwards to the rest of our eager mesh, so it continues
+	// to propagate through the overlay instead of stopping at one hop.
+	if fresh {
+		for _, name := range s.mesh.Members() {
+			if name != src {
+				s.gossip.GossipUnicast(name, buf)
+			}
+		}
 	}
 
 	return nil
 }
 
+// onControl handles a decoded mesh control envelope received from src.
+func (s *Swarm) onControl(src mesh.PeerName, ctrl control) {
+	switch ctrl.op {
+	case opGraft:
+		s.mesh.graft(src)
+	case opPrune:
+		s.mesh.prune(src)
+	case opIHave:
+		s.onIHave(src, ctrl.ids)
+	case opIWant:
+		s.onIWant(src, ctrl.ids)
+	}
+}
+
+// onIHave handles an advertisement of message IDs seen by src, requesting
+// whichever ones we don't already have in our own cache.
+func (s *Swarm) onIHave(src mesh.PeerName, ids []messageID) {
+	missing := make([]messageID, 0, len(ids))
+	for _, id := range ids {
+		if !s.cache.Has(id) {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		s.gossip.GossipUnicast(src, control{op: opIWant, ids: missing}.Encode())
+	}
+}
+
+// onIWant handles a request for message bodies, looking each one up in our
+// cache and unicasting the ones we have back to src.
+func (s *Swarm) onIWant(src mesh.PeerName, ids []messageID) {
+	frame := make(message.Frame, 0, len(ids))
+	for _, id := range ids {
+		if m, ok := s.cache.Get(id); ok {
+			frame = append(frame, *m)
+		}
+	}
+	if len(frame) > 0 {
+		s.gossip.GossipUnicast(src, append([]byte{opFrame}, frame.Encode()...))
+	}
+}
+
+// onHello handles a signed hello advertising a peer's public key. The
+// message is self-signed with the key it advertises, which proves possession
+// of the private key but nothing more; it's how the key gets learned in the
+// first place, not a statement of trust beyond "this is who's talking".
+func (s *Swarm) onHello(src mesh.PeerName, signed []byte) {
+	if len(signed) <= ed25519.SignatureSize {
+		logging.LogTarget("swarm", "dropped invalid hello", src)
+		return
+	}
+
+	split := len(signed) - ed25519.SignatureSize
+	pub, sig := ed25519.PublicKey(signed[:split]), signed[split:]
+	if !ed25519.Verify(pub, pub, sig) {
+		logging.LogTarget("swarm", "dropped invalid hello", src)
+		return
+	}
+
+	peer := s.FindPeer(src)
+	peer.Lock()
+	peer.PubKey = pub
+	peer.Unlock()
+}
+
 // NotifySubscribe notifies the swarm when a subscription occurs.
 func (s *Swarm) NotifySubscribe(conn security.ID, ssid subscription.Ssid) {
 	event := SubscriptionEvent{
@@ -277,14 +550,16 @@ func (s *Swarm) NotifySubscribe(conn security.ID, ssid subscription.Ssid) {
 		Conn: conn,
 		Ssid: ssid,
 	}
+	event.Sign(s.key)
 
 	// Add to our global state
 	s.state.Add(event.Encode())
 
-	// Create a delta for broadcasting just this operation
+	// Push the delta directly to our eager mesh peers, rather than reinforcing
+	// a full mesh by broadcasting it to every neighbor.
 	op := newSubscriptionState()
 	op.Add(event.Encode())
-	s.gossip.GossipBroadcast(op)
+	s.pushToMesh(op)
 }
 
 // NotifyUnsubscribe notifies the swarm when an unsubscription occurs.
@@ -294,18 +569,25 @@ func (s *Swarm) NotifyUnsubscribe(conn security.ID, ssid subscription.Ssid) {
 		Conn: conn,
 		Ssid: ssid,
 	}
+	event.Sign(s.key)
 
 	// Remove from our global state
 	s.state.Remove(event.Encode())
 
-	// Create a delta for broadcasting just this operation
+	// Push the delta directly to our eager mesh peers, rather than reinforcing
+	// a full mesh by broadcasting it to every neighbor.
 	op := newSubscriptionState()
 	op.Remove(event.Encode())
-	s.gossip.GossipBroadcast(op)
+	s.pushToMesh(op)
 }
 
 // Close terminates the connection.
 func (s *Swarm) Close() error {
+	if closer, ok := s.discovery.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logging.LogError("swarm", "closing discovery", err)
+		}
+	}
 	return s.router.Stop()
 }
 
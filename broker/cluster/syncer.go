@@ -0,0 +1,147 @@
+/**********************************************************************************
+* Copyright (c) 2009-2017 Misakai Ltd.
+* This program is free software: you can redistribute it and/or modify it under the
+* terms of the GNU Affero General Public License as published by the  Free Software
+* Foundation, either version 3 of the License, or(at your option) any later version.
+*
+* This program is distributed  in the hope that it  will be useful, but WITHOUT ANY
+* WARRANTY;  without even  the implied warranty of MERCHANTABILITY or FITNESS FOR A
+* PARTICULAR PURPOSE.  See the GNU Affero General Public License  for  more details.
+*
+* You should have  received a copy  of the  GNU Affero General Public License along
+* with this program. If not, see<http://www.gnu.org/licenses/>.
+************************************************************************************/
+
+package cluster
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/mesh"
+)
+
+// Defaults used when the cluster configuration leaves the syncer tunables
+// unset.
+const (
+	defaultNumActiveSyncers       = 3
+	defaultSyncerRotateInterval   = 30 * time.Second
+	defaultHistoricalSyncInterval = 5 * time.Minute
+)
+
+// syncManager splits subscription-state synchronisation into two tiers: a
+// small, rotating set of "active" peers that receive the full
+// subscriptionState, and everyone else, who only ever see the deltas pushed
+// through the eager mesh (pushToMesh). Without this, every gossip tick would ship the entire
+// state to every neighbor, which is O(N^2) traffic as the cluster grows.
+type syncManager struct {
+	sync.Mutex
+	active    map[mesh.PeerName]bool
+	numActive int
+}
+
+// newSyncManager creates a syncManager bounded to numActive active syncers.
+func newSyncManager(numActive int) *syncManager {
+	if numActive <= 0 {
+		numActive = defaultNumActiveSyncers
+	}
+	return &syncManager{
+		active:    make(map[mesh.PeerName]bool),
+		numActive: numActive,
+	}
+}
+
+// IsActive reports whether a peer currently holds active-syncer status.
+func (m *syncManager) IsActive(name mesh.PeerName) bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.active[name]
+}
+
+// Active returns a snapshot of the current active syncers.
+func (m *syncManager) Active() []mesh.PeerName {
+	m.Lock()
+	defer m.Unlock()
+	names := make([]mesh.PeerName, 0, len(m.active))
+	for name := range m.active {
+		names = append(names, name)
+	}
+	return names
+}
+
+// fill promotes candidates to active syncers until numActive is reached.
+// Candidates already active are skipped.
+func (m *syncManager) fill(candidates []mesh.PeerName) {
+	m.Lock()
+	defer m.Unlock()
+	for _, name := range candidates {
+		if len(m.active) >= m.numActive {
+			return
+		}
+		m.active[name] = true
+	}
+}
+
+// rotate demotes one random active syncer to passive and promotes one random
+// passive candidate in its place, so state isn't perpetually pulled from the
+// same neighbors. If we're below capacity, it just fills up instead.
+func (m *syncManager) rotate(candidates []mesh.PeerName) {
+	m.Lock()
+	if len(m.active) < m.numActive {
+		m.Unlock()
+		m.fill(candidates)
+		return
+	}
+
+	active := make([]mesh.PeerName, 0, len(m.active))
+	for name := range m.active {
+		active = append(active, name)
+	}
+	demoted := active[rand.Intn(len(active))]
+	delete(m.active, demoted)
+	m.Unlock()
+
+	passive := make([]mesh.PeerName, 0, len(candidates))
+	for _, name := range candidates {
+		if !m.IsActive(name) && name != demoted {
+			passive = append(passive, name)
+		}
+	}
+	if len(passive) == 0 {
+		return
+	}
+
+	m.Lock()
+	m.active[passive[rand.Intn(len(passive))]] = true
+	m.Unlock()
+}
+
+// syncerRotate is called periodically to rotate the set of active syncers,
+// and then pushes the full subscriptionState to whoever holds active status
+// afterwards. Everyone else keeps receiving only deltas, via the eager mesh
+// push in pushToMesh.
+func (s *Swarm) syncerRotate() {
+	s.syncer.rotate(s.peerNames())
+	for _, buf := range s.state.Encode() {
+		msg := append([]byte{opState}, buf...)
+		for _, name := range s.syncer.Active() {
+			s.gossip.GossipUnicast(name, msg)
+		}
+	}
+}
+
+// syncerHistorical forces a from-scratch full state exchange with one
+// randomly chosen peer, to repair any silent divergence that a dropped
+// GossipBroadcast could otherwise leave behind.
+func (s *Swarm) syncerHistorical() {
+	candidates := s.peerNames()
+	if len(candidates) == 0 {
+		return
+	}
+
+	peer := candidates[rand.Intn(len(candidates))]
+	for _, buf := range s.state.Encode() {
+		s.gossip.GossipUnicast(peer, append([]byte{opState}, buf...))
+	}
+}
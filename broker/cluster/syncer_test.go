@@ -0,0 +1,80 @@
+/**********************************************************************************
+* Copyright (c) 2009-2017 Misakai Ltd.
+* This program is free software: you can redistribute it and/or modify it under the
+* terms of the GNU Affero General Public License as published by the  Free Software
+* Foundation, either version 3 of the License, or(at your option) any later version.
+*
+* This program is distributed  in the hope that it  will be useful, but WITHOUT ANY
+* WARRANTY;  without even  the implied warranty of MERCHANTABILITY or FITNESS FOR A
+* PARTICULAR PURPOSE.  See the GNU Affero General Public License  for  more details.
+*
+* You should have  received a copy  of the  GNU Affero General Public License along
+* with this program. If not, see<http://www.gnu.org/licenses/>.
+************************************************************************************/
+
+package cluster
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weaveworks/mesh"
+)
+
+func candidatePeers(n int) []mesh.PeerName {
+	peers := make([]mesh.PeerName, n)
+	for i := range peers {
+		peers[i] = mesh.PeerName(i + 1)
+	}
+	return peers
+}
+
+func TestSyncManager_FillsUpToNumActive(t *testing.T) {
+	m := newSyncManager(3)
+	m.fill(candidatePeers(10))
+	assert.Len(t, m.Active(), 3)
+}
+
+func TestSyncManager_RotateKeepsInvariant(t *testing.T) {
+	m := newSyncManager(3)
+	candidates := candidatePeers(10)
+	m.fill(candidates)
+
+	for i := 0; i < 50; i++ {
+		m.rotate(candidates)
+		assert.LessOrEqual(t, len(m.Active()), 3)
+	}
+}
+
+func TestSyncManager_RotateChangesMembership(t *testing.T) {
+	m := newSyncManager(2)
+	candidates := candidatePeers(20)
+	m.fill(candidates)
+
+	before := m.Active()
+	changed := false
+	for i := 0; i < 20 && !changed; i++ {
+		m.rotate(candidates)
+		after := m.Active()
+		if !sameSet(before, after) {
+			changed = true
+		}
+	}
+	assert.True(t, changed, "expected rotation to eventually change the active set")
+}
+
+func sameSet(a, b []mesh.PeerName) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[mesh.PeerName]bool, len(a))
+	for _, v := range a {
+		set[v] = true
+	}
+	for _, v := range b {
+		if !set[v] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+/**********************************************************************************
+* Copyright (c) 2009-2017 Misakai Ltd.
+* This program is free software: you can redistribute it and/or modify it under the
+* terms of the GNU Affero General Public License as published by the  Free Software
+* Foundation, either version 3 of the License, or(at your option) any later version.
+*
+* This program is distributed  in the hope that it  will be useful, but WITHOUT ANY
+* WARRANTY;  without even  the implied warranty of MERCHANTABILITY or FITNESS FOR A
+* PARTICULAR PURPOSE.  See the GNU Affero General Public License  for  more details.
+*
+* You should have  received a copy  of the  GNU Affero General Public License along
+* with this program. If not, see<http://www.gnu.org/licenses/>.
+************************************************************************************/
+
+package config
+
+import "time"
+
+// ClusterConfig represents the configuration for the clustering mechanism.
+type ClusterConfig struct {
+	// NodeName sets the gossip protocol identifier. By default this is a random
+	// number.
+	NodeName string
+
+	// ListenAddr is the cluster listen address.
+	ListenAddr string
+
+	// AdvertiseAddr is the cluster advertise address.
+	AdvertiseAddr string
+
+	// Passphrase is used to initialize the shared key in order to encrypt the
+	// gossip protocol.
+	Passphrase string
+
+	// MeshD is the target number of eager peers (the mesh degree) an overlay
+	// node tries to maintain. Defaults to 6.
+	MeshD int
+
+	// MeshDlo is the lower bound on eager peers; falling below it triggers a
+	// graft on the next heartbeat. Defaults to 4.
+	MeshDlo int
+
+	// MeshDhi is the upper bound on eager peers; exceeding it triggers a prune
+	// on the next heartbeat. Defaults to 12.
+	MeshDhi int
+
+	// MeshHeartbeat is the interval between mesh maintenance heartbeats.
+	// Defaults to 1 second.
+	MeshHeartbeat time.Duration
+
+	// ClusterKey is a hex-encoded Ed25519 seed used to sign subscription
+	// events originated by this node; message frames are not signed. Takes precedence
+	// over ClusterKeyFile. A fresh, ephemeral key is generated if neither is
+	// set.
+	ClusterKey string
+
+	// ClusterKeyFile points to a file containing a hex-encoded Ed25519 seed,
+	// used when ClusterKey is not set directly.
+	ClusterKeyFile string
+
+	// NumActiveSyncers is how many peers receive the full subscriptionState;
+	// the rest only ever see deltas. Defaults to 3.
+	NumActiveSyncers int
+
+	// SyncerRotateInterval is how often one active syncer is demoted and a
+	// random passive peer promoted in its place. Defaults to 30 seconds.
+	SyncerRotateInterval time.Duration
+
+	// HistoricalSyncInterval is how often a from-scratch state exchange is
+	// forced with one randomly chosen peer, to repair silent divergence.
+	// Defaults to 5 minutes.
+	HistoricalSyncInterval time.Duration
+
+	// AntiEntropyInterval is how often a peer runs a round of pull-based
+	// digest reconciliation with a random neighbor. Defaults to 10 seconds.
+	AntiEntropyInterval time.Duration
+
+	// Discovery configures how peer addresses are found, for deployments
+	// where there's no stable bootstrap peer to gossip from.
+	Discovery DiscoveryConfig
+
+	// EventBufferSize bounds how many PeerEvents/SubscriptionEvents a single
+	// consumer channel can fall behind by before further events are dropped
+	// for it rather than blocking the swarm. Defaults to 64.
+	EventBufferSize int
+}
+
+// DiscoveryConfig selects and configures a peer discovery provider.
+type DiscoveryConfig struct {
+	// Provider is one of "static" (the default), "dns", "file" or "http".
+	Provider string
+
+	Static []string
+	DNS    DNSDiscoveryConfig
+	File   FileDiscoveryConfig
+	HTTP   HTTPDiscoveryConfig
+}
+
+// DNSDiscoveryConfig configures the "dns" discovery provider.
+type DNSDiscoveryConfig struct {
+	Name string // The SRV or A/AAAA record name to resolve.
+	SRV  bool   // Whether Name is an SRV record rather than a plain A/AAAA record.
+	Port int    // The port to pair with each resolved address, when SRV is false.
+}
+
+// FileDiscoveryConfig configures the "file" discovery provider.
+type FileDiscoveryConfig struct {
+	Path string // Path to a JSON file containing an array of peer addresses.
+}
+
+// HTTPDiscoveryConfig configures the "http" discovery provider.
+type HTTPDiscoveryConfig struct {
+	URL string // URL returning a JSON array of peer addresses.
+}